@@ -1,16 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/macrat/ayd/lib-ayd"
 )
 
@@ -20,11 +32,129 @@ var (
 
 type JsonMap map[string]any
 
+// idKind discriminates the three shapes a JSON-RPC 2.0 ID may take.
+type idKind int
+
+const (
+	idKindNull idKind = iota
+	idKindString
+	idKindNumber
+)
+
+// ID is a JSON-RPC 2.0 request/response identifier: a string, a number, or
+// JSON null. The zero value is the null ID, and ID is comparable so it can
+// be used directly as a map key.
+type ID struct {
+	kind idKind
+	str  string
+	num  int64
+}
+
+func NullID() ID           { return ID{kind: idKindNull} }
+func StringID(s string) ID { return ID{kind: idKindString, str: s} }
+func NumberID(n int64) ID  { return ID{kind: idKindNumber, num: n} }
+
+func (id ID) IsNull() bool { return id.kind == idKindNull }
+
+func (id ID) String() string {
+	switch id.kind {
+	case idKindString:
+		return id.str
+	case idKindNumber:
+		return strconv.FormatInt(id.num, 10)
+	default:
+		return "null"
+	}
+}
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	switch id.kind {
+	case idKindString:
+		return json.Marshal(id.str)
+	case idKindNumber:
+		return json.Marshal(id.num)
+	default:
+		return []byte("null"), nil
+	}
+}
+
+// invalidIDError marks an ID that was present but not a string, number, or
+// null, so callers can tell it apart from other decode failures and respond
+// with InvalidRequest instead of tearing down the connection.
+type invalidIDError struct {
+	err error
+}
+
+func (e *invalidIDError) Error() string { return e.err.Error() }
+func (e *invalidIDError) Unwrap() error { return e.err }
+
+// invalidRequestError marks a message that was valid JSON but not a valid
+// JSON-RPC 2.0 request (e.g. missing its method), so it should draw an
+// InvalidRequest response instead of tearing down the connection.
+type invalidRequestError struct {
+	err error
+}
+
+func (e *invalidRequestError) Error() string { return e.err.Error() }
+func (e *invalidRequestError) Unwrap() error { return e.err }
+
+// isMalformedRequest reports whether err marks a single message that failed
+// JSON-RPC 2.0 validation (as opposed to an I/O or JSON syntax failure),
+// meaning the connection should stay up and reply with InvalidRequest.
+func isMalformedRequest(err error) bool {
+	var idErr *invalidIDError
+	var reqErr *invalidRequestError
+	return errors.As(err, &idErr) || errors.As(err, &reqErr)
+}
+
+func (id *ID) UnmarshalJSON(data []byte) error {
+	switch {
+	case string(data) == "null":
+		*id = NullID()
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return &invalidIDError{err}
+		}
+		*id = StringID(s)
+		return nil
+	default:
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return &invalidIDError{fmt.Errorf("id must be a string, number, or null, got %s", data)}
+		}
+		*id = NumberID(n)
+		return nil
+	}
+}
+
+// Request is a JSON-RPC 2.0 request or notification. Per the spec, a
+// notification is a request with no "id" member at all, which is distinct
+// from a request whose id is explicitly null; HasID tells the two apart,
+// since ID's zero value can't.
 type Request struct {
-	JsonRPC string          `json:"jsonrpc"`
-	ID      any             `json:"id"`
-	Method  string          `json:"method"`
-	Params  json.RawMessage `json:"params"`
+	JsonRPC string
+	ID      ID
+	HasID   bool
+	Method  string
+	Params  json.RawMessage
+}
+
+// MarshalJSON omits the "id" member entirely for notifications, rather than
+// encoding it as null, per the JSON-RPC 2.0 spec.
+func (r Request) MarshalJSON() ([]byte, error) {
+	type alias struct {
+		JsonRPC string          `json:"jsonrpc"`
+		ID      *ID             `json:"id,omitempty"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params,omitempty"`
+	}
+	a := alias{JsonRPC: r.JsonRPC, Method: r.Method, Params: r.Params}
+	if r.HasID {
+		a.ID = &r.ID
+	}
+	return json.Marshal(a)
 }
 
 type ErrorCode int
@@ -49,45 +179,247 @@ func NewError(code ErrorCode, message string, data ...any) *ErrorBody {
 	}
 }
 
+func (e *ErrorBody) Error() string {
+	return e.Message
+}
+
 type Response struct {
-	JsonRPC string     `json:"jsonrpc"`
-	ID      any        `json:"id"`
-	Result  any        `json:"result,omitempty"`
-	Error   *ErrorBody `json:"error,omitempty"`
+	JsonRPC string          `json:"jsonrpc"`
+	ID      ID              `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *ErrorBody      `json:"error,omitempty"`
 }
 
-type RPCConn struct {
+// incoming is the superset of Request and Response fields, used to sniff
+// which one a freshly-decoded message actually is before routing it. ID is
+// kept as raw JSON so its presence can be checked before it's parsed.
+type incoming struct {
+	JsonRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Result  json.RawMessage `json:"result"`
+	Error   *ErrorBody      `json:"error"`
+}
+
+// Conn is a bidirectional JSON-RPC 2.0 connection: it can read and dispatch
+// incoming requests the same way RPCConn always did, but it can now also
+// issue outgoing calls and notifications to the peer over the same pipe,
+// correlating replies by ID and allowing in-flight handlers to be cancelled.
+type Conn struct {
 	r *json.Decoder
-	w *json.Encoder
+
+	wMu sync.Mutex
+	w   *json.Encoder
+
+	lastID int64
+
+	pendingMu sync.Mutex
+	pending   map[ID]chan *Response
+
+	handlingMu sync.Mutex
+	handling   map[ID]context.CancelFunc
 }
 
-func NewRPCConn(in io.Reader, out io.Writer) RPCConn {
-	return RPCConn{
-		r: json.NewDecoder(in),
-		w: json.NewEncoder(out),
+func NewConn(in io.Reader, out io.Writer) *Conn {
+	return &Conn{
+		r:        json.NewDecoder(in),
+		w:        json.NewEncoder(out),
+		pending:  make(map[ID]chan *Response),
+		handling: make(map[ID]context.CancelFunc),
 	}
 }
 
-func (c RPCConn) Read() (*Request, error) {
-	var req Request
-	if err := c.r.Decode(&req); err != nil {
+// readRaw reads the next top-level JSON value from the peer, without
+// deciding yet whether it's a single message or a batch array of them.
+func (c *Conn) readRaw() (json.RawMessage, error) {
+	var raw json.RawMessage
+	if err := c.r.Decode(&raw); err != nil {
 		return nil, err
 	}
-	return &req, nil
+	return raw, nil
+}
+
+// isBatch reports whether raw is a JSON-RPC 2.0 batch, i.e. a top-level
+// array rather than an object.
+func isBatch(raw json.RawMessage) bool {
+	trimmed := bytes.TrimLeft(raw, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// parseMessage decodes a single, non-batch top-level JSON-RPC value into
+// either an incoming Request or a Response to one of our own outgoing
+// calls. A message that fails JSON-RPC 2.0 validation (an invalid id, or a
+// missing method) is reported as an *invalidIDError or *invalidRequestError
+// so the caller can respond with InvalidRequest instead of dropping the
+// connection; see isMalformedRequest.
+func (c *Conn) parseMessage(raw json.RawMessage) (*Request, *Response, error) {
+	var msg incoming
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return nil, nil, err
+	}
+
+	hasID := msg.ID != nil
+	var id ID
+	if hasID {
+		if err := json.Unmarshal(msg.ID, &id); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if msg.Method == "" && (msg.Result != nil || msg.Error != nil) {
+		return nil, &Response{
+			JsonRPC: msg.JsonRPC,
+			ID:      id,
+			Result:  msg.Result,
+			Error:   msg.Error,
+		}, nil
+	}
+
+	if msg.Method == "" {
+		return nil, nil, &invalidRequestError{errors.New("missing method")}
+	}
+
+	return &Request{
+		JsonRPC: msg.JsonRPC,
+		ID:      id,
+		HasID:   hasID,
+		Method:  msg.Method,
+		Params:  msg.Params,
+	}, nil, nil
+}
+
+// ReadMessage reads the next non-batch message from the peer, returning
+// either an incoming Request or a Response to one of our own outgoing
+// calls.
+func (c *Conn) ReadMessage() (*Request, *Response, error) {
+	raw, err := c.readRaw()
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.parseMessage(raw)
+}
+
+func (c *Conn) write(v any) error {
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+	return c.w.Encode(v)
+}
+
+func (c *Conn) WriteResponse(res Response) error {
+	return c.write(res)
+}
+
+func (c *Conn) WriteRequest(req Request) error {
+	return c.write(req)
+}
+
+// Notify sends a one-way message to the peer that must not be replied to.
+func (c *Conn) Notify(method string, params any) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.WriteRequest(Request{
+		JsonRPC: "2.0",
+		Method:  method,
+		Params:  raw,
+	})
+}
+
+// Call sends a request to the peer and blocks until the matching response
+// arrives, the context is cancelled, or the connection is closed. On
+// success, the response's result is unmarshalled into result.
+func (c *Conn) Call(ctx context.Context, method string, params any, result any) error {
+	id := NumberID(atomic.AddInt64(&c.lastID, 1))
+
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan *Response, 1)
+	c.pendingMu.Lock()
+	c.pending[id] = ch
+	c.pendingMu.Unlock()
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, id)
+		c.pendingMu.Unlock()
+	}()
+
+	if err := c.WriteRequest(Request{
+		JsonRPC: "2.0",
+		ID:      id,
+		HasID:   true,
+		Method:  method,
+		Params:  raw,
+	}); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-ch:
+		if res.Error != nil {
+			return res.Error
+		}
+		if result == nil || res.Result == nil {
+			return nil
+		}
+		return json.Unmarshal(res.Result, result)
+	}
+}
+
+// dispatchResponse routes a Response to the pending Call waiting on it,
+// dropping and warning about it if no such call is outstanding.
+func (c *Conn) dispatchResponse(res *Response) {
+	c.pendingMu.Lock()
+	ch, ok := c.pending[res.ID]
+	c.pendingMu.Unlock()
+
+	if !ok {
+		fmt.Fprintf(os.Stderr, "warning: dropping response for unknown request id %s\n", res.ID)
+		return
+	}
+	ch <- res
+}
+
+// trackHandling records the CancelFunc for the handler currently processing
+// id, so a later $/cancelRequest notification can find and call it.
+func (c *Conn) trackHandling(id ID, cancel context.CancelFunc) {
+	c.handlingMu.Lock()
+	defer c.handlingMu.Unlock()
+	c.handling[id] = cancel
+}
+
+func (c *Conn) untrackHandling(id ID) {
+	c.handlingMu.Lock()
+	defer c.handlingMu.Unlock()
+	delete(c.handling, id)
 }
 
-func (c RPCConn) Write(res Response) error {
-	return c.w.Encode(res)
+// cancelHandling cancels the in-flight handler for id, if one is running.
+func (c *Conn) cancelHandling(id ID) {
+	c.handlingMu.Lock()
+	cancel, ok := c.handling[id]
+	c.handlingMu.Unlock()
+
+	if ok {
+		cancel()
+	}
 }
 
-type HandlerFunc func(json.RawMessage) (any, *ErrorBody)
+type HandlerFunc func(ctx context.Context, params json.RawMessage) (any, *ErrorBody)
 
 type RPCServer struct {
-	conn     RPCConn
+	conn     *Conn
 	handlers map[string]HandlerFunc
+	wg       sync.WaitGroup
 }
 
-func NewRPCServer(conn RPCConn) *RPCServer {
+func NewRPCServer(conn *Conn) *RPCServer {
 	return &RPCServer{
 		conn:     conn,
 		handlers: make(map[string]HandlerFunc),
@@ -98,55 +430,199 @@ func (s *RPCServer) SetHandler(method string, handler HandlerFunc) {
 	s.handlers[method] = handler
 }
 
+// batchConcurrency bounds how many requests of a single batch are handled
+// at once, so one huge batch can't spawn unbounded goroutines.
+const batchConcurrency = 8
+
 func (s *RPCServer) Serve() error {
 	for {
-		req, err := s.conn.Read()
+		raw, err := s.conn.readRaw()
 		if errors.Is(err, io.EOF) {
+			s.wg.Wait()
 			return nil
 		} else if err != nil {
 			return err
 		}
 
-		handler, ok := s.handlers[req.Method]
-		if !ok {
-			if req.ID == nil {
-				continue
-			}
-			s.conn.Write(Response{
-				JsonRPC: "2.0",
-				ID:      req.ID,
-				Error: &ErrorBody{
-					Code:    MethodNotFound,
-					Message: "Method not found",
-				},
-			})
+		if isBatch(raw) {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.handleBatch(raw)
+			}()
 			continue
 		}
 
-		res, errBody := handler(req.Params)
-		if errBody != nil {
-			s.conn.Write(Response{
+		req, res, err := s.conn.parseMessage(raw)
+
+		if isMalformedRequest(err) {
+			s.conn.WriteResponse(Response{
 				JsonRPC: "2.0",
-				ID:      req.ID,
-				Error:   errBody,
+				ID:      NullID(),
+				Error:   NewError(InvalidRequest, "Invalid request: %v", err),
 			})
 			continue
+		} else if err != nil {
+			return err
 		}
+
 		if res != nil {
-			s.conn.Write(Response{
-				JsonRPC: "2.0",
-				ID:      req.ID,
-				Result:  res,
-			})
+			s.conn.dispatchResponse(res)
+			continue
+		}
+
+		if req.Method == "$/cancelRequest" {
+			var p struct {
+				ID ID `json:"id"`
+			}
+			if err := json.Unmarshal(req.Params, &p); err == nil {
+				s.conn.cancelHandling(p.ID)
+			}
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(*req)
+		}()
+	}
+}
+
+// handleBatch decodes raw as a batch array, dispatches each element
+// concurrently (bounded by batchConcurrency), and writes back a single
+// array of the responses in the same order, omitting notifications. An
+// empty batch is an InvalidRequest error on its own, not an empty array,
+// and a batch of only notifications produces no response at all.
+func (s *RPCServer) handleBatch(raw json.RawMessage) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		s.conn.WriteResponse(Response{
+			JsonRPC: "2.0",
+			ID:      NullID(),
+			Error:   NewError(InvalidRequest, "Invalid batch request: %v", err),
+		})
+		return
+	}
+	if len(items) == 0 {
+		s.conn.WriteResponse(Response{
+			JsonRPC: "2.0",
+			ID:      NullID(),
+			Error:   NewError(InvalidRequest, "Batch request must not be empty"),
+		})
+		return
+	}
+
+	responses := make([]*Response, len(items))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item json.RawMessage) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			responses[i] = s.handleBatchItem(item)
+		}(i, item)
+	}
+	wg.Wait()
+
+	var batch []Response
+	for _, res := range responses {
+		if res != nil {
+			batch = append(batch, *res)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+	s.conn.write(batch)
+}
+
+// handleBatchItem processes a single element of a batch request and
+// returns the Response to include for it, or nil if it was a notification
+// or a response object routed to a pending Call.
+func (s *RPCServer) handleBatchItem(raw json.RawMessage) *Response {
+	req, res, err := s.conn.parseMessage(raw)
+	if err != nil {
+		return &Response{
+			JsonRPC: "2.0",
+			ID:      NullID(),
+			Error:   NewError(InvalidRequest, "Invalid request: %v", err),
+		}
+	}
+	if res != nil {
+		s.conn.dispatchResponse(res)
+		return nil
+	}
+	return s.process(*req)
+}
+
+func (s *RPCServer) handle(req Request) {
+	if res := s.process(req); res != nil {
+		s.conn.WriteResponse(*res)
+	}
+}
+
+// process runs req's handler to completion and returns the Response to
+// send, or nil if req was a notification and no response should ever be
+// sent for it.
+func (s *RPCServer) process(req Request) *Response {
+	handler, ok := s.handlers[req.Method]
+	if !ok {
+		if !req.HasID {
+			return nil
+		}
+		return &Response{
+			JsonRPC: "2.0",
+			ID:      req.ID,
+			Error: &ErrorBody{
+				Code:    MethodNotFound,
+				Message: "Method not found",
+			},
 		}
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if req.HasID {
+		s.conn.trackHandling(req.ID, cancel)
+		defer s.conn.untrackHandling(req.ID)
+	}
+
+	result, errBody := handler(ctx, req.Params)
+	if !req.HasID {
+		// It's a notification: the spec forbids ever replying to it,
+		// even with an error.
+		return nil
+	}
+	if errBody != nil {
+		return &Response{
+			JsonRPC: "2.0",
+			ID:      req.ID,
+			Error:   errBody,
+		}
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return &Response{
+			JsonRPC: "2.0",
+			ID:      req.ID,
+			Error:   NewError(InternalError, "Failed to marshal result: %v", err),
+		}
+	}
+	return &Response{
+		JsonRPC: "2.0",
+		ID:      req.ID,
+		Result:  raw,
+	}
 }
 
-func IgnoreHandler(params json.RawMessage) (any, *ErrorBody) {
+func IgnoreHandler(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
 	return nil, nil
 }
 
-func PongHandler(params json.RawMessage) (any, *ErrorBody) {
+func PongHandler(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
 	return JsonMap{}, nil
 }
 
@@ -163,9 +639,27 @@ type InitializeResult struct {
 }
 
 type Content struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
-	Blob string `json:"blob,omitempty"`
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+	URI      string `json:"uri,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+
+	// Data carries structured output for a "json" content part, so MCP
+	// clients that understand a tool's outputSchema can consume it
+	// directly instead of parsing a text blob.
+	Data any `json:"data,omitempty"`
+}
+
+// textContent wraps a plain-text result, kept for clients that don't look
+// at outputSchema.
+func textContent(text string) Content {
+	return Content{Type: "text", Text: text}
+}
+
+// jsonContent wraps structured data matching a tool's outputSchema.
+func jsonContent(data any) Content {
+	return Content{Type: "json", Data: data}
 }
 
 type ResourceInfo struct {
@@ -180,18 +674,24 @@ type ResourcesListResult struct {
 }
 
 type ResourcesReadRequest struct {
-	URI string `json:"uri"`
+	URI    string `json:"uri"`
+	Cursor string `json:"cursor,omitempty"`
 }
 
 type ResourcesReadResult struct {
-	URI     string    `json:"uri"`
-	Content []Content `json:"content"`
+	Contents   []Content `json:"contents"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+type ResourcesSubscribeRequest struct {
+	URI string `json:"uri"`
 }
 
 type ToolInfo struct {
-	Name        string  `json:"name"`
-	Description string  `json:"description,omitempty"`
-	InputSchema JsonMap `json:"inputSchema"`
+	Name         string  `json:"name"`
+	Description  string  `json:"description,omitempty"`
+	InputSchema  JsonMap `json:"inputSchema"`
+	OutputSchema JsonMap `json:"outputSchema,omitempty"`
 }
 
 type ToolsListResult struct {
@@ -208,8 +708,91 @@ type ToolsCallResult struct {
 	IsError bool      `json:"isError"`
 }
 
+// AydClient fetches data from an Ayd instance over HTTP, reusing connections
+// and retrying transient failures so a stalled or flapping Ayd doesn't hang
+// the MCP server indefinitely.
+type AydClient struct {
+	HTTPClient *http.Client
+
+	// MaxRetries is the maximum number of attempts for a single request,
+	// including the first one.
+	MaxRetries int
+
+	// InitialBackoff is the delay before the first retry; it doubles after
+	// each subsequent attempt.
+	InitialBackoff time.Duration
+
+	// MaxElapsed bounds the total time spent retrying a single request.
+	MaxElapsed time.Duration
+}
+
+// NewAydClient creates an AydClient with a connection-reusing *http.Client
+// bounded by timeout, and the repo's default retry policy (4 attempts,
+// 500ms initial backoff doubling each time, capped at 30s total elapsed).
+func NewAydClient(timeout time.Duration) *AydClient {
+	return &AydClient{
+		HTTPClient: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: 8,
+			},
+		},
+		MaxRetries:     4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxElapsed:     30 * time.Second,
+	}
+}
+
+// Get issues a GET request, retrying on network errors and 5xx responses
+// with exponential backoff. It gives up and returns the last error once
+// MaxRetries attempts have been made or MaxElapsed has passed.
+func (c *AydClient) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	backoff := c.InitialBackoff
+	var lastErr error
+
+	for attempt := 0; attempt < c.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if time.Since(start)+backoff > c.MaxElapsed {
+				break
+			}
+			jittered := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2+1)))
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(jittered):
+			}
+			backoff *= 2
+		}
+
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("server returned %s", resp.Status)
+			continue
+		}
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("request failed after %d attempts: %w", c.MaxRetries, lastErr)
+}
+
 type AydHandlers struct {
-	URL *url.URL
+	URL    *url.URL
+	Conn   *Conn
+	Client *AydClient
+
+	subscriptionsMu sync.Mutex
+	subscriptions   map[string]context.CancelFunc
 }
 
 func (h *AydHandlers) getEndpoint(path string) string {
@@ -220,11 +803,30 @@ func (h *AydHandlers) getEndpoint(path string) string {
 	return u.String()
 }
 
-func (h *AydHandlers) InitializeHandler(params json.RawMessage) (any, *ErrorBody) {
+// fetchStatus fetches the current status report from Ayd.
+func (h *AydHandlers) fetchStatus(ctx context.Context) (ayd.Report, error) {
+	resp, err := h.Client.Get(ctx, h.getEndpoint("status.json"))
+	if err != nil {
+		return ayd.Report{}, err
+	}
+	defer resp.Body.Close()
+
+	var report ayd.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return ayd.Report{}, err
+	}
+	return report, nil
+}
+
+func (h *AydHandlers) InitializeHandler(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
 	return InitializeResult{
 		ProtocolVersion: "2024-11-05",
 		Capabilities: JsonMap{
 			"tools": JsonMap{},
+			"resources": JsonMap{
+				"subscribe":   true,
+				"listChanged": true,
+			},
 		},
 		ServerInfo: ServerInfo{
 			Name:    "Ayd Server",
@@ -234,7 +836,34 @@ func (h *AydHandlers) InitializeHandler(params json.RawMessage) (any, *ErrorBody
 	}, nil
 }
 
-func (h *AydHandlers) ToolsList(params json.RawMessage) (any, *ErrorBody) {
+// aydLogURI is the resource URI for the whole monitoring log, optionally
+// narrowed with "since"/"until"/"query" query parameters.
+const aydLogURI = "ayd://log"
+
+// targetLogURI returns the resource URI for a single target's log.
+func targetLogURI(target string) string {
+	return "ayd://targets/" + url.PathEscape(target) + "/log"
+}
+
+// parseTargetFromLogURI extracts the target from a per-target log URI, as
+// produced by targetLogURI. ok is false if uri isn't a per-target log URI.
+func parseTargetFromLogURI(uri string) (target string, ok bool) {
+	const prefix = "ayd://targets/"
+	const suffix = "/log"
+
+	path, _, _ := strings.Cut(uri, "?")
+	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, suffix) {
+		return "", false
+	}
+	escaped := strings.TrimSuffix(strings.TrimPrefix(path, prefix), suffix)
+	target, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", false
+	}
+	return target, true
+}
+
+func (h *AydHandlers) ToolsList(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
 	return ToolsListResult{
 		Tools: []ToolInfo{
 			{
@@ -244,6 +873,16 @@ func (h *AydHandlers) ToolsList(params json.RawMessage) (any, *ErrorBody) {
 					"type":       "object",
 					"properties": JsonMap{},
 				},
+				OutputSchema: JsonMap{
+					"type": "object",
+					"properties": JsonMap{
+						"targets": JsonMap{
+							"type":  "array",
+							"items": JsonMap{"type": "string"},
+						},
+					},
+					"required": []string{"targets"},
+				},
 			},
 			{
 				Name:        "getStatusOverview",
@@ -252,6 +891,25 @@ func (h *AydHandlers) ToolsList(params json.RawMessage) (any, *ErrorBody) {
 					"type":       "object",
 					"properties": JsonMap{},
 				},
+				OutputSchema: JsonMap{
+					"type": "object",
+					"properties": JsonMap{
+						"overview": JsonMap{
+							"type": "array",
+							"items": JsonMap{
+								"type": "object",
+								"properties": JsonMap{
+									"target":     JsonMap{"type": "string"},
+									"status":     JsonMap{"type": "string"},
+									"latency_ms": JsonMap{"type": "number"},
+									"updated":    JsonMap{"type": "string"},
+								},
+								"required": []string{"target", "status", "latency_ms", "updated"},
+							},
+						},
+					},
+					"required": []string{"overview"},
+				},
 			},
 			{
 				Name:        "getTargetStatus",
@@ -266,6 +924,15 @@ func (h *AydHandlers) ToolsList(params json.RawMessage) (any, *ErrorBody) {
 					},
 					"required": []string{"uri"},
 				},
+				OutputSchema: JsonMap{
+					"type": "object",
+					"properties": JsonMap{
+						"uri":      JsonMap{"type": "string"},
+						"status":   JsonMap{"type": "string"},
+						"last_log": JsonMap{"type": "object"},
+					},
+					"required": []string{"uri", "status", "last_log"},
+				},
 			},
 			{
 				Name:        "readLog",
@@ -292,7 +959,7 @@ func (h *AydHandlers) ToolsList(params json.RawMessage) (any, *ErrorBody) {
 	}, nil
 }
 
-func (h *AydHandlers) ToolsCall(params json.RawMessage) (any, *ErrorBody) {
+func (h *AydHandlers) ToolsCall(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
 	var req ToolsCallRequest
 	if err := json.Unmarshal(params, &req); err != nil {
 		return nil, NewError(InvalidParams, "Failed to parse parameters: %v", err)
@@ -300,64 +967,89 @@ func (h *AydHandlers) ToolsCall(params json.RawMessage) (any, *ErrorBody) {
 
 	switch req.Name {
 	case "listTargets":
-		return h.ListTargets(req.Arguments)
+		return h.ListTargets(ctx, req.Arguments)
 	case "getStatusOverview":
-		return h.GetStatusOverview(req.Arguments)
+		return h.GetStatusOverview(ctx, req.Arguments)
 	case "getTargetStatus":
-		return h.GetTargetStatus(req.Arguments)
+		return h.GetTargetStatus(ctx, req.Arguments)
 	case "readLog":
-		return h.ReadLog(req.Arguments)
+		return h.ReadLog(ctx, req.Arguments)
 	default:
 		return nil, NewError(InvalidParams, "Unknown tool name: %s", req.Name)
 	}
 }
 
-func (h *AydHandlers) ListTargets(params json.RawMessage) (any, *ErrorBody) {
-	resp, err := http.Get(h.getEndpoint("/targets.json"))
+// fetchTargets fetches and sorts the list of targets Ayd is monitoring.
+func (h *AydHandlers) fetchTargets(ctx context.Context) ([]string, error) {
+	resp, err := h.Client.Get(ctx, h.getEndpoint("/targets.json"))
 	if err != nil {
-		return nil, NewError(InternalError, "Failed to fetch targets: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var targets []string
 	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
-		return nil, NewError(InternalError, "Failed to load targets: %v", err)
+		return nil, err
 	}
 
 	sort.Strings(targets)
+	return targets, nil
+}
+
+func (h *AydHandlers) ListTargets(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+	targets, err := h.fetchTargets(ctx)
+	if err != nil {
+		return nil, NewError(InternalError, "Failed to fetch targets: %v", err)
+	}
 
 	return ToolsCallResult{
 		Content: []Content{
-			{
-				Type: "text",
-				Text: strings.Join(targets, "\n"),
-			},
+			jsonContent(JsonMap{"targets": targets}),
+			textContent(strings.Join(targets, "\n")),
 		},
 	}, nil
 }
 
-func (h *AydHandlers) GetStatusOverview(params json.RawMessage) (any, *ErrorBody) {
-	report, err := ayd.Fetch(h.URL)
+// statusOverviewEntry is one target's entry in getStatusOverview's
+// structured output.
+type statusOverviewEntry struct {
+	Target    string  `json:"target"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Updated   string  `json:"updated"`
+}
+
+func (h *AydHandlers) GetStatusOverview(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+	report, err := h.fetchStatus(ctx)
 	if err != nil {
 		return nil, NewError(InternalError, "Failed to fetch status from Ayd: %v", err)
 	}
 
+	entries := []statusOverviewEntry{}
 	var results []string
 	for _, history := range report.ProbeHistory {
-		results = append(results, fmt.Sprintf("Target <%s> reported %s in %s at %s", history.Target, history.Status, history.Records[len(history.Records)-1].Latency, history.Updated))
+		if len(history.Records) == 0 {
+			continue
+		}
+		latest := history.Records[len(history.Records)-1]
+		entries = append(entries, statusOverviewEntry{
+			Target:    history.Target.String(),
+			Status:    history.Status.String(),
+			LatencyMS: float64(latest.Latency.Nanoseconds()) / 1e6,
+			Updated:   history.Updated.Format(time.RFC3339),
+		})
+		results = append(results, fmt.Sprintf("Target <%s> reported %s in %s at %s", history.Target, history.Status, latest.Latency, history.Updated))
 	}
 
 	return ToolsCallResult{
 		Content: []Content{
-			{
-				Type: "text",
-				Text: strings.Join(results, "\n"),
-			},
+			jsonContent(JsonMap{"overview": entries}),
+			textContent(strings.Join(results, "\n")),
 		},
 	}, nil
 }
 
-func (h *AydHandlers) GetTargetStatus(params json.RawMessage) (any, *ErrorBody) {
+func (h *AydHandlers) GetTargetStatus(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
 	var req struct {
 		URI string `json:"uri"`
 	}
@@ -368,35 +1060,38 @@ func (h *AydHandlers) GetTargetStatus(params json.RawMessage) (any, *ErrorBody)
 		return nil, NewError(InvalidParams, "No URIs specified")
 	}
 
-	report, err := ayd.Fetch(h.URL)
+	report, err := h.fetchStatus(ctx)
 	if err != nil {
 		return nil, NewError(InternalError, "Failed to fetch status from Ayd: %v", err)
 	}
 
 	for _, history := range report.ProbeHistory {
 		if req.URI == history.Target.String() {
-			text, err := json.Marshal(JsonMap{
+			if len(history.Records) == 0 {
+				return ToolsCallResult{
+					Content: []Content{
+						textContent(fmt.Sprintf("No log records yet for target: %s", req.URI)),
+					},
+				}, nil
+			}
+			data := JsonMap{
 				"uri":      history.Target.String(),
 				"status":   history.Status.String(),
 				"last_log": history.Records[len(history.Records)-1],
-			})
+			}
+			text, err := json.Marshal(data)
 			if err != nil {
 				return ToolsCallResult{
 					Content: []Content{
-						{
-							Type: "text",
-							Text: fmt.Sprintf("Failed to marshal response: %v", err),
-						},
+						textContent(fmt.Sprintf("Failed to marshal response: %v", err)),
 					},
 					IsError: true,
 				}, nil
 			}
 			return ToolsCallResult{
 				Content: []Content{
-					{
-						Type: "text",
-						Text: string(text),
-					},
+					jsonContent(data),
+					textContent(string(text)),
 				},
 			}, nil
 		}
@@ -404,18 +1099,326 @@ func (h *AydHandlers) GetTargetStatus(params json.RawMessage) (any, *ErrorBody)
 
 	return ToolsCallResult{
 		Content: []Content{
-			{
-				Type: "text",
-				Text: fmt.Sprintf("No such target: %s", req.URI),
-			},
+			textContent(fmt.Sprintf("No such target: %s", req.URI)),
 		},
 		IsError: false,
 	}, nil
 }
 
-func (h *AydHandlers) ReadLog(params json.RawMessage) (any, *ErrorBody) {
-	var req struct {
-		Query string `json:"query"`
+// logPageSize is the number of records returned per resources/read call
+// against a log resource. Larger logs are paged through with cursor/nextCursor.
+const logPageSize = 100
+
+// quoteQueryValue quotes s for Ayd's query language (internal/query), so a
+// value containing spaces or syntax characters (*, =, "...) is taken as a
+// literal instead of being re-parsed.
+func quoteQueryValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// buildAydQuery combines query/since/until into a single expression in
+// Ayd's query language, suitable for the /log.json endpoint's "q" parameter.
+// Ayd's /log.json only understands "q" (plus "limit"/"offset"); it has no
+// separate since/until parameters, so time bounds are expressed as "time"
+// clauses within q.
+func buildAydQuery(query, since, until string) string {
+	var parts []string
+	if query != "" {
+		parts = append(parts, query)
+	}
+	if since != "" {
+		parts = append(parts, "time>="+quoteQueryValue(since))
+	}
+	if until != "" {
+		parts = append(parts, "time<"+quoteQueryValue(until))
+	}
+	return strings.Join(parts, " ")
+}
+
+// fetchLogPage fetches records matching query/since/until from Ayd and
+// returns the page starting at cursor (an offset encoded as a decimal
+// string; "" means the start). nextCursor is "" once there's no more data.
+func (h *AydHandlers) fetchLogPage(ctx context.Context, query, since, until, cursor string) (records []ayd.Record, nextCursor string, err error) {
+	offset := 0
+	if cursor != "" {
+		offset, err = strconv.Atoi(cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor: %w", err)
+		}
+	}
+
+	u, _ := url.Parse(h.getEndpoint("/log.json"))
+	q := u.Query()
+	if aydQuery := buildAydQuery(query, since, until); aydQuery != "" {
+		q.Set("q", aydQuery)
+	}
+	u.RawQuery = q.Encode()
+
+	resp, err := h.Client.Get(ctx, u.String())
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var logs struct {
+		Records []ayd.Record `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&logs); err != nil {
+		return nil, "", err
+	}
+
+	if offset >= len(logs.Records) {
+		return nil, "", nil
+	}
+
+	end := offset + logPageSize
+	if end < len(logs.Records) {
+		nextCursor = strconv.Itoa(end)
+	} else {
+		end = len(logs.Records)
+	}
+
+	return logs.Records[offset:end], nextCursor, nil
+}
+
+// resourceLogQuery splits a log resource URI into the query/since/until
+// filters that should be sent to Ayd's /log.json endpoint.
+func resourceLogQuery(uri string) (query, since, until string, ok bool) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", "", "", false
+	}
+
+	if target, isTarget := parseTargetFromLogURI(uri); isTarget {
+		return "target=" + quoteQueryValue(target), parsed.Query().Get("since"), parsed.Query().Get("until"), true
+	}
+
+	if uri == aydLogURI || strings.HasPrefix(uri, aydLogURI+"?") {
+		q := parsed.Query()
+		return q.Get("query"), q.Get("since"), q.Get("until"), true
+	}
+
+	return "", "", "", false
+}
+
+func (h *AydHandlers) ResourcesList(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+	targets, err := h.fetchTargets(ctx)
+	if err != nil {
+		return nil, NewError(InternalError, "Failed to fetch targets: %v", err)
+	}
+
+	resources := []ResourceInfo{
+		{
+			URI:         aydLogURI,
+			Name:        "Monitoring log",
+			Description: "The full Ayd monitoring log. Supports since/until query parameters.",
+			MimeType:    "text/plain",
+		},
+	}
+	for _, target := range targets {
+		resources = append(resources, ResourceInfo{
+			URI:         targetLogURI(target),
+			Name:        fmt.Sprintf("Monitoring log for %s", target),
+			Description: fmt.Sprintf("The Ayd monitoring log for target %s. Supports since/until query parameters.", target),
+			MimeType:    "text/plain",
+		})
+	}
+
+	return ResourcesListResult{Resources: resources}, nil
+}
+
+func (h *AydHandlers) ResourcesRead(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+	var req ResourcesReadRequest
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, NewError(InvalidParams, "Failed to parse parameters: %v", err)
+	}
+
+	query, since, until, ok := resourceLogQuery(req.URI)
+	if !ok {
+		return nil, NewError(InvalidParams, "No such resource: %s", req.URI)
+	}
+
+	records, nextCursor, err := h.fetchLogPage(ctx, query, since, until, req.Cursor)
+	if err != nil {
+		return nil, NewError(InternalError, "Failed to fetch log: %v", err)
+	}
+
+	var results []string
+	for _, record := range records {
+		results = append(results, record.String())
+	}
+
+	return ResourcesReadResult{
+		Contents: []Content{
+			{
+				Type:     "text",
+				URI:      req.URI,
+				MimeType: "text/plain",
+				Text:     strings.Join(results, "\n"),
+			},
+		},
+		NextCursor: nextCursor,
+	}, nil
+}
+
+// subscribe starts polling uri for new records, pushing
+// notifications/resources/updated once something newer than what's in the
+// log right now shows up. Re-subscribing to an already-subscribed URI
+// replaces the previous subscription.
+func (h *AydHandlers) subscribe(ctx context.Context, uri string) {
+	h.subscriptionsMu.Lock()
+	defer h.subscriptionsMu.Unlock()
+
+	if h.subscriptions == nil {
+		h.subscriptions = map[string]context.CancelFunc{}
+	}
+	if cancel, ok := h.subscriptions[uri]; ok {
+		cancel()
+	}
+
+	lastSeen := h.currentLogTime(ctx, uri)
+
+	pollCtx, cancel := context.WithCancel(context.Background())
+	h.subscriptions[uri] = cancel
+	go h.pollResource(pollCtx, uri, lastSeen)
+}
+
+func (h *AydHandlers) unsubscribe(uri string) {
+	h.subscriptionsMu.Lock()
+	defer h.subscriptionsMu.Unlock()
+
+	if cancel, ok := h.subscriptions[uri]; ok {
+		cancel()
+		delete(h.subscriptions, uri)
+	}
+}
+
+// closeSubscriptions cancels every outstanding subscription's poll
+// goroutine. Call it once a connection is done for good (its RPCServer.Serve
+// has returned), since there's nobody left to notify and nothing will ever
+// call resources/unsubscribe for it.
+func (h *AydHandlers) closeSubscriptions() {
+	h.subscriptionsMu.Lock()
+	defer h.subscriptionsMu.Unlock()
+
+	for uri, cancel := range h.subscriptions {
+		cancel()
+		delete(h.subscriptions, uri)
+	}
+}
+
+// resourcePollInterval is how often a subscribed resource is checked for new records.
+const resourcePollInterval = 5 * time.Second
+
+// advancePoll decides whether records (the latest fetch for a polled
+// resource) contains anything newer than lastSeen. It returns the lastSeen
+// value to carry into the next poll and whether that's new enough to
+// justify a notifications/resources/updated push. Ayd's /log.json re-sends
+// everything in range on every call, so without this check a poll would
+// notify on every tick regardless of whether anything actually changed.
+func advancePoll(lastSeen time.Time, records []ayd.Record) (newLastSeen time.Time, shouldNotify bool) {
+	if len(records) == 0 {
+		return lastSeen, false
+	}
+
+	newest := records[len(records)-1].Time
+	if !newest.After(lastSeen) {
+		return lastSeen, false
+	}
+
+	return newest, true
+}
+
+// currentLogTime returns the time of the newest record currently matching
+// uri, or the zero time if that can't be determined (no records yet, or the
+// fetch fails). subscribe uses this as the poll's starting point so a
+// freshly subscribed client isn't immediately notified about records that
+// were already there before it subscribed.
+func (h *AydHandlers) currentLogTime(ctx context.Context, uri string) time.Time {
+	query, since, until, ok := resourceLogQuery(uri)
+	if !ok {
+		return time.Time{}
+	}
+
+	var lastSeen time.Time
+	if since != "" {
+		lastSeen, _ = time.Parse(time.RFC3339, since)
+	}
+
+	records, _, err := h.fetchLogPage(ctx, query, since, until, "")
+	if err != nil {
+		return lastSeen
+	}
+
+	newest, _ := advancePoll(lastSeen, records)
+	return newest
+}
+
+// pollResource watches uri for records newer than lastSeen and notifies the
+// client when one shows up, until ctx is cancelled (by unsubscribe, a fresh
+// subscribe to the same URI, or the connection going away).
+func (h *AydHandlers) pollResource(ctx context.Context, uri string, lastSeen time.Time) {
+	query, _, until, ok := resourceLogQuery(uri)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(resourcePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sinceParam := ""
+		if !lastSeen.IsZero() {
+			sinceParam = lastSeen.Format(time.RFC3339)
+		}
+
+		records, _, err := h.fetchLogPage(ctx, query, sinceParam, until, "")
+		if err != nil {
+			continue
+		}
+
+		var notify bool
+		lastSeen, notify = advancePoll(lastSeen, records)
+		if notify {
+			h.Conn.Notify("notifications/resources/updated", JsonMap{"uri": uri})
+		}
+	}
+}
+
+func (h *AydHandlers) ResourcesSubscribe(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+	var req ResourcesSubscribeRequest
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, NewError(InvalidParams, "Failed to parse parameters: %v", err)
+	}
+	if _, _, _, ok := resourceLogQuery(req.URI); !ok {
+		return nil, NewError(InvalidParams, "No such resource: %s", req.URI)
+	}
+
+	h.subscribe(ctx, req.URI)
+	return JsonMap{}, nil
+}
+
+func (h *AydHandlers) ResourcesUnsubscribe(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+	var req ResourcesSubscribeRequest
+	if err := json.Unmarshal(params, &req); err != nil {
+		return nil, NewError(InvalidParams, "Failed to parse parameters: %v", err)
+	}
+
+	h.unsubscribe(req.URI)
+	return JsonMap{}, nil
+}
+
+func (h *AydHandlers) ReadLog(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+	var req struct {
+		Query string `json:"query"`
 		Since string `json:"since"`
 		Until string `json:"until"`
 	}
@@ -430,10 +1433,11 @@ func (h *AydHandlers) ReadLog(params json.RawMessage) (any, *ErrorBody) {
 	q.Set("until", req.Until)
 	u.RawQuery = q.Encode()
 
-	resp, err := http.Get(u.String())
+	resp, err := h.Client.Get(ctx, u.String())
 	if err != nil {
 		return nil, NewError(InternalError, "Failed to fetch log: %v", err)
 	}
+	defer resp.Body.Close()
 
 	var logs struct {
 		Records []ayd.Record `json:"records"`
@@ -457,30 +1461,453 @@ func (h *AydHandlers) ReadLog(params json.RawMessage) (any, *ErrorBody) {
 	}, nil
 }
 
+// Transport accepts incoming client connections and hands back a Conn for
+// each one, so the MCP server can be driven over stdio, a network socket,
+// or anything else a Conn's io.Reader/io.Writer pair can wrap.
+type Transport interface {
+	// Accept blocks until a client connects and returns a Conn for it, or
+	// returns an error (io.EOF for "no more clients will ever connect")
+	// if it can't.
+	Accept() (*Conn, error)
+}
+
+// StdioTransport serves exactly one client over the process's stdin/stdout,
+// matching how this server has always been invoked as an MCP subprocess.
+type StdioTransport struct {
+	conn   *Conn
+	served bool
+}
+
+func NewStdioTransport() *StdioTransport {
+	return &StdioTransport{conn: NewConn(os.Stdin, os.Stdout)}
+}
+
+func (t *StdioTransport) Accept() (*Conn, error) {
+	if t.served {
+		return nil, io.EOF
+	}
+	t.served = true
+	return t.conn, nil
+}
+
+// wsRWC adapts a *websocket.Conn into the io.Reader/io.Writer pair a Conn
+// needs, carrying each JSON-RPC message as a single WebSocket text frame.
+type wsRWC struct {
+	*websocket.Conn
+	r io.Reader
+}
+
+func (w *wsRWC) Read(p []byte) (int, error) {
+	for {
+		if w.r == nil {
+			_, r, err := w.Conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			w.r = r
+		}
+		n, err := w.r.Read(p)
+		if n > 0 {
+			return n, nil
+		}
+		if err == io.EOF {
+			w.r = nil
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *wsRWC) Write(p []byte) (int, error) {
+	if err := w.Conn.WriteMessage(websocket.TextMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WebSocketTransport serves each client over its own WebSocket connection,
+// carrying JSON-RPC frames bidirectionally.
+type WebSocketTransport struct {
+	listener net.Listener
+	upgrader websocket.Upgrader
+	conns    chan *Conn
+	errs     chan error
+}
+
+func NewWebSocketTransport(addr string) (*WebSocketTransport, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &WebSocketTransport{
+		listener: ln,
+		conns:    make(chan *Conn),
+		errs:     make(chan error, 1),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", t.handle)
+	go func() {
+		t.errs <- http.Serve(ln, mux)
+	}()
+
+	return t, nil
+}
+
+func (t *WebSocketTransport) handle(w http.ResponseWriter, r *http.Request) {
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	t.conns <- NewConn(&wsRWC{Conn: conn}, &wsRWC{Conn: conn})
+}
+
+// Addr returns the address the transport is listening on, e.g. to discover
+// the actual port chosen when NewWebSocketTransport was given ":0".
+func (t *WebSocketTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+func (t *WebSocketTransport) Accept() (*Conn, error) {
+	select {
+	case conn := <-t.conns:
+		return conn, nil
+	case err := <-t.errs:
+		return nil, err
+	}
+}
+
+// sseEvent is one message delivered over an SSE stream, numbered so a
+// reconnecting client can resume with a Last-Event-ID header.
+type sseEvent struct {
+	id   int
+	data []byte
+}
+
+// sseHistoryLimit bounds how many past events an SSE session keeps around to
+// serve a Last-Event-ID resume; older events are forgotten.
+const sseHistoryLimit = 100
+
+// sseSession is one MCP client connected over HTTP+SSE: requests arrive via
+// POST and are fed into pw for the session's Conn to read, while responses
+// and notifications are pushed out over the SSE stream via push.
+type sseSession struct {
+	pw *io.PipeWriter
+
+	mu          sync.Mutex
+	nextEventID int
+	history     []sseEvent
+
+	out       chan sseEvent
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newSSESession(pw *io.PipeWriter) *sseSession {
+	return &sseSession{
+		pw:     pw,
+		out:    make(chan sseEvent, 16),
+		closed: make(chan struct{}),
+	}
+}
+
+// close tears the session down: its Conn observes EOF (pw.Close unblocks
+// the pipe reader feeding it), and any push blocked on a full out channel
+// gives up instead of wedging the writer forever. Safe to call more than
+// once, e.g. once per handleSSE disconnect if the client reconnects and
+// disconnects again.
+func (s *sseSession) close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.pw.Close()
+	})
+}
+
+func (s *sseSession) push(data []byte) {
+	s.mu.Lock()
+	s.nextEventID++
+	ev := sseEvent{id: s.nextEventID, data: append([]byte(nil), data...)}
+	s.history = append(s.history, ev)
+	if len(s.history) > sseHistoryLimit {
+		s.history = s.history[len(s.history)-sseHistoryLimit:]
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.out <- ev:
+	case <-s.closed:
+	}
+}
+
+// replay returns the buffered events with an id greater than afterID, for
+// resuming a reconnecting client at the right spot.
+func (s *sseSession) replay(afterID int) []sseEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []sseEvent
+	for _, ev := range s.history {
+		if ev.id > afterID {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// sseWriter is the io.Writer side of an sseSession's Conn: every write is
+// one JSON-RPC message pushed out over the client's SSE stream.
+type sseWriter struct {
+	session *sseSession
+}
+
+func (w sseWriter) Write(p []byte) (int, error) {
+	w.session.push(p)
+	return len(p), nil
+}
+
+func newSessionID() string {
+	var b [16]byte
+	cryptorand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// SSETransport serves MCP's HTTP+SSE transport: a client GETs ssePath to
+// open an event stream and learn where to POST its outgoing messages, then
+// POSTs postPath?sessionId=... for each JSON-RPC message it sends. A client
+// that reconnects to ssePath with the same sessionId and a Last-Event-ID
+// header resumes from the session's buffered history instead of losing
+// notifications sent while it was disconnected.
+type SSETransport struct {
+	listener net.Listener
+	ssePath  string
+	postPath string
+
+	sessionsMu sync.Mutex
+	sessions   map[string]*sseSession
+
+	conns chan *Conn
+	errs  chan error
+}
+
+func NewSSETransport(addr string) (*SSETransport, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &SSETransport{
+		listener: ln,
+		ssePath:  "/sse",
+		postPath: "/message",
+		sessions: map[string]*sseSession{},
+		conns:    make(chan *Conn),
+		errs:     make(chan error, 1),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(t.ssePath, t.handleSSE)
+	mux.HandleFunc(t.postPath, t.handlePost)
+	go func() {
+		t.errs <- http.Serve(ln, mux)
+	}()
+
+	return t, nil
+}
+
+// Addr returns the address the transport is listening on, e.g. to discover
+// the actual port chosen when NewSSETransport was given ":0".
+func (t *SSETransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+func (t *SSETransport) Accept() (*Conn, error) {
+	select {
+	case conn := <-t.conns:
+		return conn, nil
+	case err := <-t.errs:
+		return nil, err
+	}
+}
+
+func (t *SSETransport) handleSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := r.URL.Query().Get("sessionId")
+	lastEventID, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+
+	t.sessionsMu.Lock()
+	session, resuming := t.sessions[sessionID]
+	var newConn *Conn
+	if !resuming {
+		pr, pw := io.Pipe()
+		sessionID = newSessionID()
+		session = newSSESession(pw)
+		t.sessions[sessionID] = session
+		newConn = NewConn(pr, sseWriter{session})
+	}
+	t.sessionsMu.Unlock()
+
+	if newConn != nil {
+		select {
+		case t.conns <- newConn:
+		case <-r.Context().Done():
+			t.closeSession(sessionID, session)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if !resuming {
+		fmt.Fprintf(w, "event: endpoint\ndata: %s?sessionId=%s\n\n", t.postPath, sessionID)
+		flusher.Flush()
+	}
+
+	for _, ev := range session.replay(lastEventID) {
+		fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", ev.id, ev.data)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case ev := <-session.out:
+			fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", ev.id, ev.data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			t.closeSession(sessionID, session)
+			return
+		}
+	}
+}
+
+// closeSession removes sessionID from t.sessions and tears its sseSession
+// down. Once this runs, a reconnect with the same sessionId is treated as
+// unknown and gets a fresh session, matching the lifetime of the GET
+// stream that owns it.
+func (t *SSETransport) closeSession(sessionID string, session *sseSession) {
+	t.sessionsMu.Lock()
+	delete(t.sessions, sessionID)
+	t.sessionsMu.Unlock()
+
+	session.close()
+}
+
+func (t *SSETransport) handlePost(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("sessionId")
+
+	t.sessionsMu.Lock()
+	session, ok := t.sessions[sessionID]
+	t.sessionsMu.Unlock()
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := session.pw.Write(body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func newTransport(kind, listen string) (Transport, error) {
+	switch kind {
+	case "stdio":
+		return NewStdioTransport(), nil
+	case "sse":
+		return NewSSETransport(listen)
+	case "ws":
+		return NewWebSocketTransport(listen)
+	default:
+		return nil, fmt.Errorf("unknown transport: %s (want stdio, sse, or ws)", kind)
+	}
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <AYD_URL>\n", os.Args[0])
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout for a single request to Ayd.")
+	maxRetries := flag.Int("max-retries", 4, "Maximum number of attempts for a request to Ayd, including the first one.")
+	transportKind := flag.String("transport", "stdio", "Transport to serve MCP over: stdio, sse, or ws.")
+	listen := flag.String("listen", ":8080", "Address to listen on for the sse and ws transports.")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <AYD_URL>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	aydURL, err := url.Parse(os.Args[1])
+	aydURL, err := url.Parse(flag.Arg(0))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Invalid Ayd URL: %v\n", err)
 		os.Exit(1)
 	}
-	handlers := AydHandlers{URL: aydURL}
 
-	server := NewRPCServer(NewRPCConn(os.Stdin, os.Stdout))
-	server.SetHandler("initialize", handlers.InitializeHandler)
-	server.SetHandler("notifications/initialized", IgnoreHandler)
-	server.SetHandler("ping", PongHandler)
-	server.SetHandler("tools/list", handlers.ToolsList)
-	server.SetHandler("tools/call", handlers.ToolsCall)
-
-	fmt.Fprintf(os.Stderr, "Ayd Server for %s is running on stdio!\n", aydURL)
-	err = server.Serve()
+	client := NewAydClient(*timeout)
+	client.MaxRetries = *maxRetries
 
+	transport, err := newTransport(*transportKind, *listen)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to start transport: %v\n", err)
 		os.Exit(1)
 	}
+
+	fmt.Fprintf(os.Stderr, "Ayd Server for %s is running on %s!\n", aydURL, *transportKind)
+
+	for {
+		conn, err := transport.Accept()
+		if err != nil {
+			if err == io.EOF {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		handlers := &AydHandlers{URL: aydURL, Conn: conn, Client: client}
+
+		server := NewRPCServer(conn)
+		server.SetHandler("initialize", handlers.InitializeHandler)
+		server.SetHandler("notifications/initialized", IgnoreHandler)
+		server.SetHandler("ping", PongHandler)
+		server.SetHandler("tools/list", handlers.ToolsList)
+		server.SetHandler("tools/call", handlers.ToolsCall)
+		server.SetHandler("resources/list", handlers.ResourcesList)
+		server.SetHandler("resources/read", handlers.ResourcesRead)
+		server.SetHandler("resources/subscribe", handlers.ResourcesSubscribe)
+		server.SetHandler("resources/unsubscribe", handlers.ResourcesUnsubscribe)
+
+		if *transportKind == "stdio" {
+			if err := server.Serve(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		go func() {
+			if err := server.Serve(); err != nil {
+				fmt.Fprintf(os.Stderr, "Session ended: %v\n", err)
+			}
+			handlers.closeSubscriptions()
+		}()
+	}
 }
@@ -0,0 +1,865 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/gorilla/websocket"
+	"github.com/macrat/ayd/lib-ayd"
+)
+
+func TestID_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    ID
+		wantErr bool
+	}{
+		{"string", `"abc"`, StringID("abc"), false},
+		{"number", `42`, NumberID(42), false},
+		{"null", `null`, NullID(), false},
+		{"array", `[1]`, ID{}, true},
+		{"object", `{}`, ID{}, true},
+		{"bool", `true`, ID{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var id ID
+			err := json.Unmarshal([]byte(tt.input), &id)
+
+			if tt.wantErr {
+				var idErr *invalidIDError
+				if !errors.As(err, &idErr) {
+					t.Fatalf("expected an *invalidIDError, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if id != tt.want {
+				t.Errorf("got %#v, want %#v", id, tt.want)
+			}
+		})
+	}
+}
+
+func TestID_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		id   ID
+		want string
+	}{
+		{"string", StringID("abc"), `"abc"`},
+		{"number", NumberID(42), `42`},
+		{"null", NullID(), `null`},
+		{"zero value", ID{}, `null`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := json.Marshal(tt.id)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(raw) != tt.want {
+				t.Errorf("got %s, want %s", raw, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequest_NotificationVsNullID(t *testing.T) {
+	conn := NewConn(nil, nil)
+
+	tests := []struct {
+		name       string
+		input      string
+		wantHasID  bool
+		wantID     ID
+		wantMethod string
+	}{
+		{"no id member is a notification", `{"jsonrpc":"2.0","method":"foo"}`, false, ID{}, "foo"},
+		{"id: null is a request awaiting a null-id reply", `{"jsonrpc":"2.0","id":null,"method":"foo"}`, true, NullID(), "foo"},
+		{"id: 1 is an ordinary request", `{"jsonrpc":"2.0","id":1,"method":"foo"}`, true, NumberID(1), "foo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn.r = json.NewDecoder(strings.NewReader(tt.input))
+
+			req, res, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res != nil {
+				t.Fatalf("expected a request, got a response")
+			}
+			if req.HasID != tt.wantHasID {
+				t.Errorf("HasID = %v, want %v", req.HasID, tt.wantHasID)
+			}
+			if req.ID != tt.wantID {
+				t.Errorf("ID = %#v, want %#v", req.ID, tt.wantID)
+			}
+			if req.Method != tt.wantMethod {
+				t.Errorf("Method = %q, want %q", req.Method, tt.wantMethod)
+			}
+		})
+	}
+}
+
+func TestConn_ReadMessage_InvalidID(t *testing.T) {
+	conn := NewConn(strings.NewReader(`{"jsonrpc":"2.0","id":[1,2],"method":"foo"}`), nil)
+
+	_, _, err := conn.ReadMessage()
+
+	var idErr *invalidIDError
+	if !errors.As(err, &idErr) {
+		t.Fatalf("expected an *invalidIDError, got %v", err)
+	}
+}
+
+// serveOnce feeds input to a fresh server with handlers registered, runs
+// Serve to completion (it returns on EOF after input is exhausted), and
+// returns everything written back.
+func serveOnce(t *testing.T, input string, register func(s *RPCServer)) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	server := NewRPCServer(NewConn(strings.NewReader(input), &out))
+	register(server)
+
+	if err := server.Serve(); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	return out.String()
+}
+
+func TestRPCServer_Batch(t *testing.T) {
+	register := func(s *RPCServer) {
+		s.SetHandler("sum", func(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+			var nums []int
+			json.Unmarshal(params, &nums)
+			total := 0
+			for _, n := range nums {
+				total += n
+			}
+			return total, nil
+		})
+		s.SetHandler("notify_hello", IgnoreHandler)
+		s.SetHandler("subtract", func(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+			var nums []int
+			json.Unmarshal(params, &nums)
+			return nums[0] - nums[1], nil
+		})
+		s.SetHandler("get_data", func(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+			return []any{"hello", 5}, nil
+		})
+	}
+
+	// Mirrors the batch example from the JSON-RPC 2.0 spec.
+	input := `[
+		{"jsonrpc": "2.0", "method": "sum", "params": [1,2,4], "id": "1"},
+		{"jsonrpc": "2.0", "method": "notify_hello", "params": [7]},
+		{"jsonrpc": "2.0", "method": "subtract", "params": [42,23], "id": "2"},
+		{"foo": "boo"},
+		{"jsonrpc": "2.0", "method": "foo.get", "params": {"name": "myself"}, "id": "5"},
+		{"jsonrpc": "2.0", "method": "get_data", "id": "9"}
+	]`
+
+	out := serveOnce(t, input, register)
+
+	var got []Response
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("response wasn't a JSON array: %v (%s)", err, out)
+	}
+
+	byID := map[string]Response{}
+	for _, res := range got {
+		byID[res.ID.String()] = res
+	}
+
+	if len(got) != 5 {
+		t.Fatalf("expected 5 responses (notify_hello omitted), got %d: %s", len(got), out)
+	}
+	if string(byID["1"].Result) != "7" {
+		t.Errorf(`id "1" result = %s, want 7`, byID["1"].Result)
+	}
+	if string(byID["2"].Result) != "19" {
+		t.Errorf(`id "2" result = %s, want 19`, byID["2"].Result)
+	}
+	if res, ok := byID["null"]; !ok || res.Error == nil || res.Error.Code != InvalidRequest {
+		t.Errorf(`{"foo":"boo"} should produce an InvalidRequest with id: null, got %+v`, res)
+	}
+	if res := byID["5"]; res.Error == nil || res.Error.Code != MethodNotFound {
+		t.Errorf(`id "5" should be MethodNotFound, got %+v`, res)
+	}
+	if string(byID["9"].Result) != `["hello",5]` {
+		t.Errorf(`id "9" result = %s, want ["hello",5]`, byID["9"].Result)
+	}
+}
+
+func TestRPCServer_Batch_Empty(t *testing.T) {
+	out := serveOnce(t, `[]`, func(s *RPCServer) {})
+
+	var res Response
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("an empty batch must get a single error object, not an array: %v (%s)", err, out)
+	}
+	if res.Error == nil || res.Error.Code != InvalidRequest {
+		t.Errorf("expected InvalidRequest, got %+v", res)
+	}
+}
+
+func TestRPCServer_Batch_ResponseElement(t *testing.T) {
+	// A batch element that is itself a response object (e.g. a server
+	// replying to one of our own Call requests) must be routed to
+	// dispatchResponse, not treated as a request to process.
+	input := `[
+		{"jsonrpc": "2.0", "result": 5, "id": "1"},
+		{"jsonrpc": "2.0", "method": "ping", "id": "2"}
+	]`
+
+	out := serveOnce(t, input, func(s *RPCServer) {
+		s.SetHandler("ping", func(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+			return "pong", nil
+		})
+	})
+
+	var got []Response
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("response wasn't a JSON array: %v (%s)", err, out)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 response (response element omitted), got %d: %s", len(got), out)
+	}
+	if got[0].ID.String() != "2" || string(got[0].Result) != `"pong"` {
+		t.Errorf("got %+v, want id 2 result \"pong\"", got[0])
+	}
+}
+
+func TestTargetLogURI_RoundTrip(t *testing.T) {
+	tests := []string{
+		"http://example.com/",
+		"ping:example.com",
+		"https://example.com/path?query=value",
+	}
+
+	for _, target := range tests {
+		t.Run(target, func(t *testing.T) {
+			uri := targetLogURI(target)
+			got, ok := parseTargetFromLogURI(uri)
+			if !ok {
+				t.Fatalf("parseTargetFromLogURI(%q) failed to recognize %q as a per-target log URI", uri, uri)
+			}
+			if got != target {
+				t.Errorf("got %q, want %q", got, target)
+			}
+		})
+	}
+}
+
+func TestParseTargetFromLogURI_NotATargetURI(t *testing.T) {
+	tests := []string{aydLogURI, "ayd://log?since=2024-01-01T00:00:00Z", "ayd://targets/"}
+
+	for _, uri := range tests {
+		t.Run(uri, func(t *testing.T) {
+			if _, ok := parseTargetFromLogURI(uri); ok {
+				t.Errorf("parseTargetFromLogURI(%q) should not recognize it as a per-target log URI", uri)
+			}
+		})
+	}
+}
+
+func TestBuildAydQuery(t *testing.T) {
+	tests := []struct {
+		name                string
+		query, since, until string
+		want                string
+	}{
+		{"empty", "", "", "", ""},
+		{"query only", "target=\"http://example.com/\"", "", "", `target="http://example.com/"`},
+		{"since only", "", "2024-01-01T00:00:00Z", "", `time>="2024-01-01T00:00:00Z"`},
+		{"until only", "", "", "2024-01-02T00:00:00Z", `time<"2024-01-02T00:00:00Z"`},
+		{
+			"all three",
+			`target="http://example.com/"`, "2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z",
+			`target="http://example.com/" time>="2024-01-01T00:00:00Z" time<"2024-01-02T00:00:00Z"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildAydQuery(tt.query, tt.since, tt.until); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdvancePoll(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+
+	tests := []struct {
+		name         string
+		lastSeen     time.Time
+		records      []ayd.Record
+		wantLastSeen time.Time
+		wantNotify   bool
+	}{
+		{"no records", t0, nil, t0, false},
+		{"only the already-seen record (Ayd re-sent it unchanged)", t0, []ayd.Record{{Time: t0}}, t0, false},
+		{"a genuinely newer record", t0, []ayd.Record{{Time: t0}, {Time: t1}}, t1, true},
+		{"first poll, never seen anything yet", time.Time{}, []ayd.Record{{Time: t0}}, t0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotLastSeen, gotNotify := advancePoll(tt.lastSeen, tt.records)
+			if !gotLastSeen.Equal(tt.wantLastSeen) || gotNotify != tt.wantNotify {
+				t.Errorf("got (%v, %v), want (%v, %v)", gotLastSeen, gotNotify, tt.wantLastSeen, tt.wantNotify)
+			}
+		})
+	}
+}
+
+func TestResourceLogQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		uri       string
+		wantQuery string
+		wantSince string
+		wantUntil string
+		wantOK    bool
+	}{
+		{"global log", aydLogURI, "", "", "", true},
+		{"global log with range", aydLogURI + "?since=2024-01-01T00:00:00Z&until=2024-01-02T00:00:00Z", "", "2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z", true},
+		{"target log", targetLogURI("http://example.com/"), `target="http://example.com/"`, "", "", true},
+		{"target log with range", targetLogURI("http://example.com/") + "?since=2024-01-01T00:00:00Z", `target="http://example.com/"`, "2024-01-01T00:00:00Z", "", true},
+		{"unrelated uri", "ayd://unknown", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, since, until, ok := resourceLogQuery(tt.uri)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if query != tt.wantQuery || since != tt.wantSince || until != tt.wantUntil {
+				t.Errorf("got (%q, %q, %q), want (%q, %q, %q)", query, since, until, tt.wantQuery, tt.wantSince, tt.wantUntil)
+			}
+		})
+	}
+}
+
+func TestAydClient_Get_RetriesThenSucceeds(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := NewAydClient(time.Second)
+	client.InitialBackoff = time.Millisecond
+
+	resp, err := client.Get(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("expected 3 attempts (2 failures + 1 success), got %d", n)
+	}
+}
+
+func TestAydClient_Get_GivesUpAfterMaxRetries(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewAydClient(time.Second)
+	client.InitialBackoff = time.Millisecond
+	client.MaxRetries = 3
+
+	_, err := client.Get(context.Background(), server.URL)
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("expected exactly MaxRetries (3) attempts, got %d", n)
+	}
+}
+
+func TestAydClient_Get_DoesNotRetryOnSuccess(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewAydClient(time.Second)
+	client.InitialBackoff = time.Millisecond
+
+	resp, err := client.Get(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("a 4xx response shouldn't be retried, got %d attempts", n)
+	}
+}
+
+// readSSEEvent reads one "event: .../data: .../\n\n" block from an SSE
+// stream, ignoring any "id:" line.
+func readSSEEvent(t *testing.T, r *bufio.Reader) (event, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading SSE stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+
+		switch {
+		case line == "":
+			if event != "" || data != "" {
+				return event, data
+			}
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestSSETransport_RoundTrip(t *testing.T) {
+	transport, err := NewSSETransport("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start transport: %v", err)
+	}
+
+	connCh := make(chan *Conn, 1)
+	go func() {
+		conn, err := transport.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		connCh <- conn
+	}()
+
+	base := "http://" + transport.Addr().String()
+
+	resp, err := http.Get(base + "/sse")
+	if err != nil {
+		t.Fatalf("GET /sse: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	event, data := readSSEEvent(t, reader)
+	if event != "endpoint" {
+		t.Fatalf("expected an endpoint event first, got %q", event)
+	}
+
+	conn := <-connCh
+	server := NewRPCServer(conn)
+	server.SetHandler("ping", PongHandler)
+	go server.Serve()
+
+	postResp, err := http.Post(base+data, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	postResp.Body.Close()
+	if postResp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202 Accepted, got %d", postResp.StatusCode)
+	}
+
+	_, data = readSSEEvent(t, reader)
+	var res Response
+	if err := json.Unmarshal([]byte(data), &res); err != nil {
+		t.Fatalf("response wasn't JSON: %v (%s)", err, data)
+	}
+	if res.Error != nil {
+		t.Fatalf("unexpected error response: %+v", res.Error)
+	}
+}
+
+func TestWebSocketTransport_RoundTrip(t *testing.T) {
+	transport, err := NewWebSocketTransport("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start transport: %v", err)
+	}
+
+	connCh := make(chan *Conn, 1)
+	go func() {
+		conn, err := transport.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		connCh <- conn
+	}()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+transport.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+
+	conn := <-connCh
+	server := NewRPCServer(conn)
+	server.SetHandler("ping", PongHandler)
+	go server.Serve()
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"method":"ping"}`)); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+
+	var res Response
+	if err := json.Unmarshal(msg, &res); err != nil {
+		t.Fatalf("response wasn't JSON: %v (%s)", err, msg)
+	}
+	if res.Error != nil {
+		t.Fatalf("unexpected error response: %+v", res.Error)
+	}
+}
+
+// validateAgainstSchema checks that value, once round-tripped through JSON,
+// satisfies the given JSON Schema (as produced by a ToolInfo.OutputSchema).
+func validateAgainstSchema(t *testing.T, schema JsonMap, value any) {
+	t.Helper()
+
+	schemaJSON, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("failed to marshal schema: %v", err)
+	}
+	var s jsonschema.Schema
+	if err := json.Unmarshal(schemaJSON, &s); err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	resolved, err := s.Resolve(nil)
+	if err != nil {
+		t.Fatalf("failed to resolve schema: %v", err)
+	}
+
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("failed to marshal value: %v", err)
+	}
+	var instance any
+	if err := json.Unmarshal(valueJSON, &instance); err != nil {
+		t.Fatalf("failed to unmarshal value: %v", err)
+	}
+
+	if err := resolved.Validate(instance); err != nil {
+		t.Errorf("value %s doesn't match schema: %v", valueJSON, err)
+	}
+}
+
+// outputSchemaFor returns the declared OutputSchema for a tool, failing the
+// test if the tool doesn't exist.
+func outputSchemaFor(t *testing.T, tools []ToolInfo, name string) JsonMap {
+	t.Helper()
+	for _, tool := range tools {
+		if tool.Name == name {
+			return tool.OutputSchema
+		}
+	}
+	t.Fatalf("no such tool: %s", name)
+	return nil
+}
+
+// jsonContentOf returns the "json" content part's Data from a tool call
+// result, failing the test if there isn't one.
+func jsonContentOf(t *testing.T, result ToolsCallResult) any {
+	t.Helper()
+	for _, c := range result.Content {
+		if c.Type == "json" {
+			return c.Data
+		}
+	}
+	t.Fatalf("no json content part in %+v", result)
+	return nil
+}
+
+func TestAydHandlers_ToolOutputsMatchSchema(t *testing.T) {
+	aydServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/targets.json":
+			json.NewEncoder(w).Encode([]string{"http://example.com/"})
+		case "/status.json":
+			fmt.Fprint(w, `{
+				"probe_history": [{
+					"target": "http://example.com/",
+					"status": "HEALTHY",
+					"updated": "2024-01-01T00:00:00Z",
+					"records": [{"time":"2024-01-01T00:00:00Z","status":"HEALTHY","latency":12.5,"target":"http://example.com/","message":""}]
+				}],
+				"current_incidents": [],
+				"incident_history": [],
+				"reported_at": "2024-01-01T00:00:00Z"
+			}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer aydServer.Close()
+
+	aydURL, err := url.Parse(aydServer.URL + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := &AydHandlers{URL: aydURL, Client: NewAydClient(time.Second)}
+
+	toolsList, errBody := h.ToolsList(context.Background(), nil)
+	if errBody != nil {
+		t.Fatalf("ToolsList: %+v", errBody)
+	}
+	tools := toolsList.(ToolsListResult).Tools
+
+	tests := []struct {
+		name string
+		call func() (any, *ErrorBody)
+	}{
+		{"listTargets", func() (any, *ErrorBody) { return h.ListTargets(context.Background(), nil) }},
+		{"getStatusOverview", func() (any, *ErrorBody) { return h.GetStatusOverview(context.Background(), nil) }},
+		{"getTargetStatus", func() (any, *ErrorBody) {
+			return h.GetTargetStatus(context.Background(), json.RawMessage(`{"uri":"http://example.com/"}`))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, errBody := tt.call()
+			if errBody != nil {
+				t.Fatalf("%s: %+v", tt.name, errBody)
+			}
+			data := jsonContentOf(t, result.(ToolsCallResult))
+			validateAgainstSchema(t, outputSchemaFor(t, tools, tt.name), data)
+		})
+	}
+}
+
+func TestAydHandlers_EmptyRecordsDoesNotPanic(t *testing.T) {
+	aydServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status.json":
+			fmt.Fprint(w, `{
+				"probe_history": [{
+					"target": "http://example.com/",
+					"status": "UNKNOWN",
+					"updated": "2024-01-01T00:00:00Z",
+					"records": []
+				}],
+				"current_incidents": [],
+				"incident_history": [],
+				"reported_at": "2024-01-01T00:00:00Z"
+			}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer aydServer.Close()
+
+	aydURL, err := url.Parse(aydServer.URL + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := &AydHandlers{URL: aydURL, Client: NewAydClient(time.Second)}
+
+	overview, errBody := h.GetStatusOverview(context.Background(), nil)
+	if errBody != nil {
+		t.Fatalf("GetStatusOverview: %+v", errBody)
+	}
+	data := jsonContentOf(t, overview.(ToolsCallResult)).(JsonMap)
+	if entries := data["overview"].([]statusOverviewEntry); len(entries) != 0 {
+		t.Errorf("expected a target with no records to be omitted, got %d entries", len(entries))
+	}
+
+	status, errBody := h.GetTargetStatus(context.Background(), json.RawMessage(`{"uri":"http://example.com/"}`))
+	if errBody != nil {
+		t.Fatalf("GetTargetStatus: %+v", errBody)
+	}
+	result := status.(ToolsCallResult)
+	if result.IsError {
+		t.Errorf("expected a plain text fallback, not an error result: %+v", result)
+	}
+	for _, c := range result.Content {
+		if c.Type == "json" {
+			t.Errorf("expected no json content for a target with no records, got %+v", c)
+		}
+	}
+}
+
+func TestRPCServer_Batch_OnlyNotifications(t *testing.T) {
+	var called atomic.Int32
+	out := serveOnce(t, `[{"jsonrpc":"2.0","method":"notify"},{"jsonrpc":"2.0","method":"notify"}]`, func(s *RPCServer) {
+		s.SetHandler("notify", func(ctx context.Context, params json.RawMessage) (any, *ErrorBody) {
+			called.Add(1)
+			return nil, nil
+		})
+	})
+
+	if n := called.Load(); n != 2 {
+		t.Errorf("expected both notifications to run, called = %d", n)
+	}
+	if out != "" {
+		t.Errorf("a batch of only notifications must produce no response, got %q", out)
+	}
+}
+
+func TestSSETransport_ClientDisconnectCleansUpSession(t *testing.T) {
+	transport, err := NewSSETransport("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start transport: %v", err)
+	}
+
+	connCh := make(chan *Conn, 1)
+	go func() {
+		conn, err := transport.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		connCh <- conn
+	}()
+
+	base := "http://" + transport.Addr().String()
+
+	resp, err := http.Get(base + "/sse")
+	if err != nil {
+		t.Fatalf("GET /sse: %v", err)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	event, _ := readSSEEvent(t, reader)
+	if event != "endpoint" {
+		t.Fatalf("expected an endpoint event first, got %q", event)
+	}
+
+	conn := <-connCh
+	go NewRPCServer(conn).Serve()
+
+	resp.Body.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		transport.sessionsMu.Lock()
+		n := len(transport.sessions)
+		transport.sessionsMu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session wasn't removed after client disconnect, %d left", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAydHandlers_CloseSubscriptions(t *testing.T) {
+	h := &AydHandlers{}
+	var canceled int
+	h.subscriptions = map[string]context.CancelFunc{
+		"a": func() { canceled++ },
+		"b": func() { canceled++ },
+	}
+
+	h.closeSubscriptions()
+
+	if canceled != 2 {
+		t.Errorf("expected every subscription to be cancelled, got %d", canceled)
+	}
+	if len(h.subscriptions) != 0 {
+		t.Errorf("expected subscriptions to be cleared, got %d left", len(h.subscriptions))
+	}
+}
+
+func TestAydHandlers_CurrentLogTime(t *testing.T) {
+	aydServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"records": [
+			{"time":"2024-01-01T00:00:00Z","status":"HEALTHY","latency":1,"target":"http://example.com/","message":""},
+			{"time":"2024-01-02T00:00:00Z","status":"HEALTHY","latency":1,"target":"http://example.com/","message":""}
+		]}`)
+	}))
+	defer aydServer.Close()
+
+	aydURL, err := url.Parse(aydServer.URL + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := &AydHandlers{URL: aydURL, Client: NewAydClient(time.Second)}
+
+	got := h.currentLogTime(context.Background(), aydLogURI)
+	want, _ := time.Parse(time.RFC3339, "2024-01-02T00:00:00Z")
+	if !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAydHandlers_SubscribeSeedsLastSeenAgainstExistingRecords(t *testing.T) {
+	aydServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"records": [
+			{"time":"2024-01-01T00:00:00Z","status":"HEALTHY","latency":1,"target":"http://example.com/","message":""}
+		]}`)
+	}))
+	defer aydServer.Close()
+
+	aydURL, err := url.Parse(aydServer.URL + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := &AydHandlers{URL: aydURL, Client: NewAydClient(time.Second)}
+
+	lastSeen := h.currentLogTime(context.Background(), aydLogURI)
+
+	query, _, until, ok := resourceLogQuery(aydLogURI)
+	if !ok {
+		t.Fatalf("expected aydLogURI to resolve to a log query")
+	}
+	records, _, err := h.fetchLogPage(context.Background(), query, "", until, "")
+	if err != nil {
+		t.Fatalf("fetchLogPage: %v", err)
+	}
+
+	if _, notify := advancePoll(lastSeen, records); notify {
+		t.Errorf("expected no notification for records that already existed at subscribe time")
+	}
+}